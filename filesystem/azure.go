@@ -1,26 +1,217 @@
 package filesystem
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/MSOpenTech/azure-sdk-for-go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
 )
 
+// ErrChecksumMismatch is returned by a reader from OpenReadCloser when the
+// downloaded bytes' MD5 does not match the blob's stored Content-MD5.
+var ErrChecksumMismatch = errors.New("azureClient : downloaded content's MD5 does not match the blob's Content-MD5")
+
+// ErrIfMatchMD5Mismatch is returned by OpenWriteCloser when the caller
+// supplies WriteOptions.IfMatchMD5 and an existing blob's Content-MD5 does
+// not match it.
+var ErrIfMatchMD5Mismatch = errors.New("azureClient : existing blob's Content-MD5 does not match IfMatchMD5")
+
+const (
+	// DefaultWriteChunkSize is the amount of data AzureFile buffers before
+	// staging it as a block, if the caller does not request a different size.
+	DefaultWriteChunkSize = 4 * 1024 * 1024
+	// MaxWriteChunkSize is the largest block Azure block blobs accept.
+	MaxWriteChunkSize = 4000 * 1024 * 1024
+	// DefaultWriteConcurrency is the number of PutBlock calls AzureFile keeps
+	// in flight at once, if the caller does not request a different value.
+	DefaultWriteConcurrency = 8
+
+	// DefaultRequestTimeout bounds how long a single blob call, and each of
+	// its retries, is allowed to run before it is canceled. The download in
+	// OpenReadCloserContext is not bounded by it.
+	DefaultRequestTimeout = 10 * time.Minute
+	// DefaultRetryMaxAttempts is the number of times a retryable call is
+	// attempted in total, including the first try.
+	DefaultRetryMaxAttempts = 12
+	// DefaultRetryInitialDelay is the backoff before the second attempt;
+	// it doubles on every attempt after that.
+	DefaultRetryInitialDelay = 10 * time.Second
+
+	// copyStatusPollInterval is how often RenameContext polls the
+	// destination blob's CopyStatus while waiting for an asynchronous
+	// server-side copy to finish.
+	copyStatusPollInterval = 1 * time.Second
+)
+
+// RetryOptions configures the per-call timeout and retry/backoff behavior
+// every blob operation on an AzureClient goes through. Zero fields fall back
+// to the corresponding Default* constant.
+type RetryOptions struct {
+	Timeout      time.Duration
+	MaxAttempts  int
+	InitialDelay time.Duration
+}
+
+func (r RetryOptions) withDefaults() RetryOptions {
+	if r.Timeout <= 0 {
+		r.Timeout = DefaultRequestTimeout
+	}
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if r.InitialDelay <= 0 {
+		r.InitialDelay = DefaultRetryInitialDelay
+	}
+	return r
+}
+
+// withRetry runs op under a fresh per-call timeout derived from parent,
+// retrying with exponential backoff while op's error is retryable. Each
+// retry gets its own full-length timeout, so a slow multi-page operation
+// that resets the timeout per page (see GlobContext) doesn't die midway.
+func withRetry(parent context.Context, retry RetryOptions, op func(ctx context.Context) error) error {
+	retry = retry.withDefaults()
+	delay := retry.InitialDelay
+	var lastErr error
+	for attempt := 1; attempt <= retry.MaxAttempts; attempt++ {
+		callCtx, cancel := context.WithTimeout(parent, retry.Timeout)
+		lastErr = op(callCtx)
+		cancel()
+		if lastErr == nil {
+			return nil
+		}
+		if attempt == retry.MaxAttempts || !isRetryableAzureError(lastErr) {
+			return lastErr
+		}
+		select {
+		case <-time.After(delay):
+		case <-parent.Done():
+			return parent.Err()
+		}
+		delay *= 2
+	}
+	return lastErr
+}
+
+// isRetryableAzureError reports whether err looks transient: a network-level
+// error, or an HTTP 429/500/503 response from the service.
+func isRetryableAzureError(err error) bool {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable:
+			return true
+		default:
+			return false
+		}
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// ClientOptions configures the pipeline used by an AzureClient: retries,
+// request logging, and the underlying HTTP transport. It is an alias for
+// azblob.ClientOptions so callers can configure it using the upstream SDK's
+// own documentation.
+type ClientOptions = azblob.ClientOptions
+
+// AzureClient talks to an Azure Blob Storage account through
+// github.com/Azure/azure-sdk-for-go/sdk/storage/azblob. It implements the
+// taskgraph filesystem interface (Exists, Rename, OpenReadCloser,
+// OpenWriteCloser, Glob) against "container/blob" style paths.
 type AzureClient struct {
-	client     *storage.StorageClient
-	blobClient *storage.BlobStorageClient
+	client *azblob.Client
+	retry  RetryOptions
+
+	// glob, if set, overrides GlobContext's listing backend; tests use this
+	// to drive pagination and prefix filtering against a fake backend
+	// instead of a live account. Left nil in every NewAzureClient* constructor.
+	glob globBackend
 }
 
+// WithRetryOptions returns c after replacing its retry/timeout behavior.
+// Call it right after construction, e.g.
+// client, err := NewAzureClientWithSharedKey(...); client = client.WithRetryOptions(opts).
+func (c *AzureClient) WithRetryOptions(opts RetryOptions) *AzureClient {
+	c.retry = opts.withDefaults()
+	return c
+}
+
+// blockBlobClient is the subset of *blockblob.Client that AzureFile drives.
+// Narrowing it to an interface lets tests round-trip AzureFile's staged
+// upload logic against a fake blob backend instead of a live account.
+type blockBlobClient interface {
+	StageBlock(ctx context.Context, base64BlockID string, body io.ReadSeekCloser, options *blockblob.StageBlockOptions) (blockblob.StageBlockResponse, error)
+	CommitBlockList(ctx context.Context, base64BlockIDs []string, options *blockblob.CommitBlockListOptions) (blockblob.CommitBlockListResponse, error)
+}
+
+// AzureFile implements io.WriteCloser over a block blob. Write buffers
+// incoming bytes into chunkSize-sized chunks; each full chunk is staged as a
+// block with PutBlock as soon as it fills, using up to concurrency in-flight
+// requests. Close stages any trailing partial chunk and then commits the
+// full, ordered block list with a single PutBlockList call.
 type AzureFile struct {
-	path   string
-	logger *log.Logger
-	client *storage.BlobStorageClient
+	path      string
+	logger    *log.Logger
+	blockBlob blockBlobClient
+	ctx       context.Context
+	retry     RetryOptions
+
+	chunkSize   int
+	concurrency int
+
+	pending   []byte
+	blockIDs  []string
+	nextBlock uint64
+	inFlight  chan struct{}
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	err       error
+	committed bool
+
+	// md5 accumulates the MD5 of every byte passed to Write, in order, so
+	// Close can commit it as the blob's Content-MD5 property.
+	md5 hash.Hash
+}
+
+// WriteOptions customizes the staged block upload performed by an
+// AzureFile: how much data is buffered per block (up to MaxWriteChunkSize),
+// how many PutBlock calls may be in flight at once, and an optional
+// optimistic-concurrency guard against an existing blob.
+type WriteOptions struct {
+	ChunkSize   int
+	Concurrency int
+
+	// IfMatchMD5, if non-nil, refuses to overwrite an existing blob whose
+	// Content-MD5 differs from it, returning ErrIfMatchMD5Mismatch. It has
+	// no effect if no blob currently exists at the target path. This gives
+	// taskgraph an idempotent-retry primitive: a task that regenerates the
+	// same output after a failed attempt can confirm it isn't clobbering
+	// somebody else's differently-keyed result.
+	IfMatchMD5 []byte
 }
 
 // convertToAzurePath function
@@ -43,11 +234,29 @@ func convertToAzurePath(name string) (string, string, error) {
 // Only check the BlobName if exist or not
 // User should Provide corresponding ContainerName
 func (c *AzureClient) Exists(name string) (bool, error) {
+	return c.ExistsContext(context.Background(), name)
+}
+
+// ExistsContext is Exists, bounded by ctx and retried with c's RetryOptions.
+func (c *AzureClient) ExistsContext(ctx context.Context, name string) (bool, error) {
 	containerName, blobName, err := convertToAzurePath(name)
 	if err != nil {
 		return false, err
 	}
-	return c.blobClient.BlobExists(containerName, blobName)
+	var exists bool
+	err = withRetry(ctx, c.retry, func(ctx context.Context) error {
+		_, err := c.client.ServiceClient().NewContainerClient(containerName).NewBlobClient(blobName).GetProperties(ctx, nil)
+		if err != nil {
+			if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+				exists = false
+				return nil
+			}
+			return err
+		}
+		exists = true
+		return nil
+	})
+	return exists, err
 }
 
 // AzureClient -> Rename function
@@ -56,7 +265,12 @@ func (c *AzureClient) Exists(name string) (bool, error) {
 // when finished, delete the source blob.
 // http://stackoverflow.com/questions/3734672/azure-storage-blob-rename
 func (c *AzureClient) Rename(oldpath, newpath string) error {
-	exist, err := c.Exists(oldpath)
+	return c.RenameContext(context.Background(), oldpath, newpath)
+}
+
+// RenameContext is Rename, bounded by ctx and retried with c's RetryOptions.
+func (c *AzureClient) RenameContext(ctx context.Context, oldpath, newpath string) error {
+	exist, err := c.ExistsContext(ctx, oldpath)
 	if err != nil {
 		return err
 	}
@@ -71,14 +285,27 @@ func (c *AzureClient) Rename(oldpath, newpath string) error {
 	if err != nil {
 		return err
 	}
-	dstBlobUrl := c.blobClient.GetBlobUrl(dstContainerName, dstBlobName)
-	srcBlobUrl := c.blobClient.GetBlobUrl(srcContainerName, srcBlobName)
-	err = c.blobClient.CopyBlob(dstContainerName, dstBlobName, srcBlobUrl)
+	srcBlobClient := c.client.ServiceClient().NewContainerClient(srcContainerName).NewBlobClient(srcBlobName)
+	dstBlobClient := c.client.ServiceClient().NewContainerClient(dstContainerName).NewBlobClient(dstBlobName)
+	err = withRetry(ctx, c.retry, func(ctx context.Context) error {
+		_, err := dstBlobClient.StartCopyFromURL(ctx, srcBlobClient.URL(), nil)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	if dstBlobUrl != srcBlobUrl {
-		err = c.blobClient.DeleteBlob(srcContainerName, srcBlobName)
+	// StartCopyFromURL only queues the copy; it returns before the service
+	// has actually moved any bytes. Deleting the source before the copy
+	// finishes would delete it out from under an in-progress copy, so wait
+	// for it to land first.
+	if err := waitForCopy(ctx, dstBlobClient); err != nil {
+		return err
+	}
+	if srcBlobClient.URL() != dstBlobClient.URL() {
+		err = withRetry(ctx, c.retry, func(ctx context.Context) error {
+			_, err := srcBlobClient.Delete(ctx, nil)
+			return err
+		})
 		if err != nil {
 			return err
 		}
@@ -86,137 +313,515 @@ func (c *AzureClient) Rename(oldpath, newpath string) error {
 	return nil
 }
 
+// waitForCopy polls dst's CopyStatus until the server-side copy
+// StartCopyFromURL queued against it finishes, returning nil once it
+// succeeds. It returns an error if the copy is aborted, fails, or ctx is
+// done first.
+func waitForCopy(ctx context.Context, dst *blob.Client) error {
+	for {
+		props, err := dst.GetProperties(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if props.CopyStatus == nil {
+			return nil
+		}
+		switch *props.CopyStatus {
+		case blob.CopyStatusTypeSuccess:
+			return nil
+		case blob.CopyStatusTypeAborted:
+			return fmt.Errorf("azureClient : copy to %s was aborted", dst.URL())
+		case blob.CopyStatusTypeFailed:
+			return fmt.Errorf("azureClient : copy to %s failed: %s", dst.URL(), stringFromPtr(props.CopyStatusDescription))
+		}
+		select {
+		case <-time.After(copyStatusPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// stringFromPtr returns *p, or "" if p is nil.
+func stringFromPtr(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
 // AzureClient -> OpenReadCloser function
 // implement by the providing function
 func (c *AzureClient) OpenReadCloser(name string) (io.ReadCloser, error) {
+	return c.OpenReadCloserContext(context.Background(), name)
+}
+
+// OpenReadCloserContext is OpenReadCloser, bounded by ctx and retried with
+// c's RetryOptions. Only establishing the download is retried; once
+// streaming begins, read errors are returned to the caller as-is. The
+// download runs under ctx itself, so RetryOptions.Timeout does not bound it.
+// If the blob carries a Content-MD5 property, the returned ReadCloser
+// verifies the downloaded bytes against it and returns ErrChecksumMismatch
+// from Read at EOF on a mismatch.
+func (c *AzureClient) OpenReadCloserContext(ctx context.Context, name string) (io.ReadCloser, error) {
 	containerName, blobName, err := convertToAzurePath(name)
 	if err != nil {
 		return nil, err
 	}
-	return c.blobClient.GetBlob(containerName, blobName)
-}
-
-//AzureClient -> OpenWriteCloser function
-// If not exist, Create corresponding Container and blob.
-// At present, AzureFile.Write has a capacity restriction(10 * 1024 * 1024 bytes).
-// I will implent unlimited version in the future.
-func (c *AzureClient) OpenWriteCloser(name string) (io.WriteCloser, error) {
-	exist, err := c.Exists(name)
+	var body io.ReadCloser
+	var wantMD5 []byte
+	// withRetry cancels the context it hands to each attempt as soon as the
+	// attempt returns, which would cut off reads of the body; download under
+	// the caller's ctx instead.
+	err = withRetry(ctx, c.retry, func(context.Context) error {
+		resp, err := c.client.DownloadStream(ctx, containerName, blobName, nil)
+		if err != nil {
+			return err
+		}
+		body = resp.Body
+		wantMD5 = resp.ContentMD5
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	if len(wantMD5) == 0 {
+		return body, nil
+	}
+	return &checksumVerifyingReader{rc: body, hash: md5.New(), want: wantMD5}, nil
+}
+
+// checksumVerifyingReader wraps a blob download, comparing the rolling MD5
+// of everything read against the blob's stored Content-MD5 once the
+// underlying reader reaches EOF.
+type checksumVerifyingReader struct {
+	rc   io.ReadCloser
+	hash hash.Hash
+	want []byte
+}
+
+func (r *checksumVerifyingReader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.hash.Write(p[:n])
+	}
+	if err == io.EOF && !bytes.Equal(r.hash.Sum(nil), r.want) {
+		return n, ErrChecksumMismatch
+	}
+	return n, err
+}
+
+func (r *checksumVerifyingReader) Close() error {
+	return r.rc.Close()
+}
+
+// AzureClient -> OpenWriteCloser function
+// If not exist, Create corresponding Container.
+// Uploads stream to the blob in DefaultWriteChunkSize blocks; see
+// OpenWriteCloserWithOptions to customize chunk size and concurrency.
+func (c *AzureClient) OpenWriteCloser(name string) (io.WriteCloser, error) {
+	return c.OpenWriteCloserContext(context.Background(), name, nil)
+}
+
+// OpenWriteCloserWithOptions is OpenWriteCloser with a caller-supplied
+// WriteOptions. A nil opts, or zero fields within it, fall back to
+// DefaultWriteChunkSize and DefaultWriteConcurrency.
+func (c *AzureClient) OpenWriteCloserWithOptions(name string, opts *WriteOptions) (io.WriteCloser, error) {
+	return c.OpenWriteCloserContext(context.Background(), name, opts)
+}
+
+// OpenWriteCloserContext is OpenWriteCloserWithOptions, bounded by ctx.
+// ctx is retained on the returned AzureFile and governs every StageBlock and
+// CommitBlockList call made by subsequent Write/Close calls.
+func (c *AzureClient) OpenWriteCloserContext(ctx context.Context, name string, opts *WriteOptions) (io.WriteCloser, error) {
 	containerName, blobName, err := convertToAzurePath(name)
 	if err != nil {
 		return nil, err
 	}
-	if !exist {
-		_, err = c.blobClient.CreateContainerIfNotExists(containerName, storage.ContainerAccessTypeBlob)
+	if opts != nil && len(opts.IfMatchMD5) > 0 {
+		err = withRetry(ctx, c.retry, func(ctx context.Context) error {
+			props, err := c.client.ServiceClient().NewContainerClient(containerName).NewBlobClient(blobName).GetProperties(ctx, nil)
+			if err != nil {
+				if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+					return nil
+				}
+				return err
+			}
+			if !bytes.Equal(props.ContentMD5, opts.IfMatchMD5) {
+				return ErrIfMatchMD5Mismatch
+			}
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
-		err = c.blobClient.CreateBlockBlob(containerName, blobName)
-		if err != nil {
-			return nil, err
+	}
+	err = withRetry(ctx, c.retry, func(ctx context.Context) error {
+		_, err := c.client.ServiceClient().NewContainerClient(containerName).Create(ctx, nil)
+		if err != nil && bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := DefaultWriteChunkSize
+	concurrency := DefaultWriteConcurrency
+	if opts != nil {
+		if opts.ChunkSize > 0 {
+			chunkSize = opts.ChunkSize
 		}
+		if opts.Concurrency > 0 {
+			concurrency = opts.Concurrency
+		}
+	}
+	if chunkSize > MaxWriteChunkSize {
+		return nil, fmt.Errorf("azureClient : chunk size %d exceeds the block-blob maximum of %d", chunkSize, MaxWriteChunkSize)
 	}
 	return &AzureFile{
-		path:   name,
-		logger: log.New(os.Stdout, "", log.Lshortfile|log.LstdFlags),
-		client: c.blobClient,
+		path:        name,
+		logger:      log.New(os.Stdout, "", log.Lshortfile|log.LstdFlags),
+		blockBlob:   c.client.ServiceClient().NewContainerClient(containerName).NewBlockBlobClient(blobName),
+		ctx:         ctx,
+		retry:       c.retry,
+		chunkSize:   chunkSize,
+		concurrency: concurrency,
+		inFlight:    make(chan struct{}, concurrency),
+		md5:         md5.New(),
 	}, nil
 }
 
 func (f *AzureFile) Write(b []byte) (int, error) {
-	cnt, blob, err := convertToAzurePath(f.path)
-	if err != nil {
-		return 0, nil
-	}
-	blockList, err := f.client.GetBlockList(cnt, blob, storage.BlockListTypeAll)
-	if err != nil {
-		return 0, nil
-	}
-	blocksLen := len(blockList.CommittedBlocks) + len(blockList.UncommittedBlocks)
-	blockId := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%011d\n", blocksLen-1)))
-	err = f.client.PutBlock(cnt, blob, blockId, b)
-	if err != nil {
-		return 0, err
-	}
-	blockList, err = f.client.GetBlockList(cnt, blob, storage.BlockListTypeAll)
-	if err != nil {
+	if err := f.pendingErr(); err != nil {
 		return 0, err
 	}
-	amendList := []storage.Block{}
-	for _, v := range blockList.CommittedBlocks {
-		amendList = append(amendList, storage.Block{v.Name, storage.BlockStatusCommitted})
+	f.md5.Write(b)
+	f.pending = append(f.pending, b...)
+	for len(f.pending) >= f.chunkSize {
+		chunk := f.pending[:f.chunkSize]
+		f.pending = append([]byte(nil), f.pending[f.chunkSize:]...)
+		f.stageChunk(chunk)
 	}
-	for _, v := range blockList.UncommittedBlocks {
-		amendList = append(amendList, storage.Block{v.Name, storage.BlockStatusUncommitted})
-	}
-	err = f.client.PutBlockList(cnt, blob, amendList)
-	if err != nil {
+	if err := f.pendingErr(); err != nil {
 		return 0, err
 	}
-	return 0, nil
+	return len(b), nil
+}
+
+// blockIDForIndex renders a block's position as the fixed-width, base64
+// encoded block ID Azure requires; fixed width gives every block ID of a
+// blob the same length. Close commits the blocks in the order stageChunk
+// appended their IDs to f.blockIDs.
+func blockIDForIndex(index uint64) string {
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%032d", index)))
+}
+
+// stageChunk assigns the next block ID to chunk and stages it asynchronously,
+// bounded by f.concurrency in-flight requests.
+func (f *AzureFile) stageChunk(chunk []byte) {
+	blockID := blockIDForIndex(f.nextBlock)
+	f.nextBlock++
+	f.blockIDs = append(f.blockIDs, blockID)
+
+	f.inFlight <- struct{}{}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		defer func() { <-f.inFlight }()
+		err := withRetry(f.ctx, f.retry, func(ctx context.Context) error {
+			body := streaming.NopCloser(bytes.NewReader(chunk))
+			_, err := f.blockBlob.StageBlock(ctx, blockID, body, nil)
+			return err
+		})
+		if err != nil {
+			f.setErr(err)
+		}
+	}()
+}
+
+func (f *AzureFile) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
 }
 
+func (f *AzureFile) pendingErr() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
+
+// Close stages any buffered trailing bytes as a final block, waits for all
+// in-flight PutBlock calls to finish, and commits the full ordered block
+// list with a single PutBlockList call.
 func (f *AzureFile) Close() error {
-	return nil
+	if f.committed {
+		return fmt.Errorf("azureClient : %s already closed", f.path)
+	}
+	f.committed = true
+	if len(f.pending) > 0 {
+		f.stageChunk(f.pending)
+		f.pending = nil
+	}
+	f.wg.Wait()
+	if err := f.pendingErr(); err != nil {
+		return err
+	}
+	commitMD5 := f.md5.Sum(nil)
+	return withRetry(f.ctx, f.retry, func(ctx context.Context) error {
+		_, err := f.blockBlob.CommitBlockList(ctx, f.blockIDs, &blockblob.CommitBlockListOptions{
+			HTTPHeaders: &blob.HTTPHeaders{BlobContentMD5: commitMD5},
+		})
+		return err
+	})
 }
 
 // AzureClient -> Glob function
 // only supports '*', '?'
 // Syntax:
 // cntName?/part.*
+// Blob names may themselves contain "/", so everything after the first "/"
+// is taken as the blob pattern, e.g. "cnt/a/b/part.*" matches blob "a/b/part.1"
+// inside container "cnt".
 func (c *AzureClient) Glob(pattern string) (matches []string, err error) {
-	afterSplit := strings.Split(pattern, "/")
-	cntPattern, blobPattern := afterSplit[0], afterSplit[1]
-	if len(afterSplit) != 2 {
-		return nil, fmt.Errorf("Glob pattern should follow the Syntax")
+	return c.GlobContext(context.Background(), pattern)
+}
+
+// literalGlobPrefix returns the longest prefix of pattern that contains none
+// of the glob metacharacters '*', '?', '['. Azure's ListBlobs Prefix
+// parameter lets the service do this filtering instead of taskgraph walking
+// every blob in the container.
+func literalGlobPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?["); i >= 0 {
+		return pattern[:i]
 	}
-	resp, err := c.blobClient.ListContainers(storage.ListContainersParameters{Prefix: ""})
+	return pattern
+}
+
+// containerPage and blobPage hold just the names GlobContext matches
+// against, independent of the SDK's response shape. Keeping globBackend's
+// return types this thin is what lets tests drive GlobContext's pagination
+// and prefix-filtering logic against a fake pager backend, without having to
+// construct real azblob response values.
+type containerPage struct{ names []string }
+type blobPage struct{ names []string }
+
+// containerPager and blobPager abstract a single paginated listing call;
+// *runtime.Pager already exposes this same More()/NextPage(ctx) shape, so
+// sdkContainerPager/sdkBlobPager only need to adapt its response type.
+type containerPager interface {
+	More() bool
+	NextPage(ctx context.Context) (containerPage, error)
+}
+type blobPager interface {
+	More() bool
+	NextPage(ctx context.Context) (blobPage, error)
+}
+
+// globBackend is the subset of listing calls GlobContext needs from an
+// Azure service client: paginated container listing, and paginated,
+// prefix-filtered blob listing within one container.
+type globBackend interface {
+	listContainers() containerPager
+	listBlobs(containerName, prefix string) blobPager
+}
+
+// sdkGlobBackend is globBackend backed by a live *service.Client.
+type sdkGlobBackend struct {
+	svc *service.Client
+}
+
+func (b *sdkGlobBackend) listContainers() containerPager {
+	return &sdkContainerPager{p: b.svc.NewListContainersPager(nil)}
+}
+
+func (b *sdkGlobBackend) listBlobs(containerName, prefix string) blobPager {
+	return &sdkBlobPager{p: b.svc.NewContainerClient(containerName).NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix: &prefix,
+	})}
+}
+
+type sdkContainerPager struct {
+	p *runtime.Pager[azblob.ListContainersResponse]
+}
+
+func (w *sdkContainerPager) More() bool { return w.p.More() }
+
+func (w *sdkContainerPager) NextPage(ctx context.Context) (containerPage, error) {
+	resp, err := w.p.NextPage(ctx)
 	if err != nil {
-		return nil, err
+		return containerPage{}, err
 	}
-	for _, cnt := range resp.Containers {
-		matched, err := path.Match(cntPattern, cnt.Name)
-		if err != nil {
-			return nil, err
-		}
-		if !matched {
-			continue
-		}
-		resp, err := c.blobClient.ListBlobs(cnt.Name, storage.ListBlobsParameters{Marker: ""})
-		if err != nil {
+	page := containerPage{}
+	for _, cnt := range resp.ContainerItems {
+		page.names = append(page.names, *cnt.Name)
+	}
+	return page, nil
+}
+
+type sdkBlobPager struct {
+	p *runtime.Pager[azblob.ListBlobsFlatResponse]
+}
+
+func (w *sdkBlobPager) More() bool { return w.p.More() }
+
+func (w *sdkBlobPager) NextPage(ctx context.Context) (blobPage, error) {
+	resp, err := w.p.NextPage(ctx)
+	if err != nil {
+		return blobPage{}, err
+	}
+	page := blobPage{}
+	for _, v := range resp.Segment.BlobItems {
+		page.names = append(page.names, *v.Name)
+	}
+	return page, nil
+}
+
+// globBackendOrDefault returns c's test-injected globBackend if set, or a
+// sdkGlobBackend wrapping c's live service client otherwise.
+func (c *AzureClient) globBackendOrDefault() globBackend {
+	if c.glob != nil {
+		return c.glob
+	}
+	return &sdkGlobBackend{svc: c.client.ServiceClient()}
+}
+
+// GlobContext is Glob, bounded by ctx. Each ListContainers and ListBlobs page
+// fetch is retried independently with c's RetryOptions, and gets its own
+// fresh per-call timeout, so a listing spanning many pages can't be killed
+// by a single slow page or die partway through a long pagination; the
+// underlying pagers already follow Azure's continuation token (NextMarker)
+// across calls to More()/NextPage until the listing is exhausted.
+func (c *AzureClient) GlobContext(ctx context.Context, pattern string) (matches []string, err error) {
+	slash := strings.Index(pattern, "/")
+	if slash < 0 {
+		return nil, fmt.Errorf("Glob pattern should follow the Syntax")
+	}
+	cntPattern, blobPattern := pattern[:slash], pattern[slash+1:]
+	blobPrefix := literalGlobPrefix(blobPattern)
+	backend := c.globBackendOrDefault()
+	pager := backend.listContainers()
+	for pager.More() {
+		var page containerPage
+		if err := withRetry(ctx, c.retry, func(ctx context.Context) error {
+			p, err := pager.NextPage(ctx)
+			if err != nil {
+				return err
+			}
+			page = p
+			return nil
+		}); err != nil {
 			return nil, err
 		}
-		for _, v := range resp.Blobs {
-			matched, err := path.Match(blobPattern, v.Name)
+		for _, cntName := range page.names {
+			matched, err := path.Match(cntPattern, cntName)
 			if err != nil {
 				return nil, err
 			}
-			if matched {
-				matches = append(matches, cnt.Name+"/"+v.Name)
+			if !matched {
+				continue
+			}
+			blobPager := backend.listBlobs(cntName, blobPrefix)
+			for blobPager.More() {
+				var bp blobPage
+				if err := withRetry(ctx, c.retry, func(ctx context.Context) error {
+					p, err := blobPager.NextPage(ctx)
+					if err != nil {
+						return err
+					}
+					bp = p
+					return nil
+				}); err != nil {
+					return nil, err
+				}
+				for _, name := range bp.names {
+					matched, err := path.Match(blobPattern, name)
+					if err != nil {
+						return nil, err
+					}
+					if matched {
+						matches = append(matches, cntName+"/"+name)
+					}
+				}
 			}
 		}
 	}
 	return matches, nil
 }
 
-// NewAzureClient function
-// NewClient constructs a StorageClient and blobStorageClinet.
-// This should be used if the caller wants to specify
-// whether to use HTTPS, a specific REST API version or a
-// custom storage endpoint than Azure Public Cloud.
-// Recommended API version "2014-02-14"
-// synax :
-// AzurestorageAccountName, AzurestorageAccountKey, "core.chinacloudapi.cn", "2014-02-14", true
-func NewAzureClient(accountName, accountKey, blobServiceBaseUrl, apiVersion string, useHttps bool) (*AzureClient, error) {
-	cli, err := storage.NewClient(accountName, accountKey, blobServiceBaseUrl, apiVersion, useHttps)
+// NewAzureClient constructs an AzureClient authenticated with an
+// azcore.TokenCredential, e.g. one produced by azidentity for a service
+// principal, managed identity, or workload identity. accountURL is the
+// account's blob endpoint, e.g. "https://<account>.blob.core.windows.net".
+func NewAzureClient(accountURL string, cred azcore.TokenCredential, options *ClientOptions) (*AzureClient, error) {
+	cli, err := azblob.NewClient(accountURL, cred, options)
 	if err != nil {
 		return nil, err
 	}
-	return &AzureClient{
-		client:     &cli,
-		blobClient: cli.GetBlobService(),
-	}, nil
+	return &AzureClient{client: cli, retry: RetryOptions{}.withDefaults()}, nil
+}
+
+// NewAzureClientWithSharedKey constructs an AzureClient authenticated with
+// an account name and key, the same credential the previous SDK-backed
+// implementation required of every caller.
+func NewAzureClientWithSharedKey(accountName, accountKey string, options *ClientOptions) (*AzureClient, error) {
+	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return nil, err
+	}
+	accountURL := fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
+	cli, err := azblob.NewClientWithSharedKeyCredential(accountURL, cred, options)
+	if err != nil {
+		return nil, err
+	}
+	return &AzureClient{client: cli, retry: RetryOptions{}.withDefaults()}, nil
+}
+
+// NewAzureClientWithSAS constructs an AzureClient authenticated with a
+// pre-signed SAS URL, e.g. "https://<account>.blob.core.windows.net?<sas>".
+func NewAzureClientWithSAS(sasURL string, options *ClientOptions) (*AzureClient, error) {
+	cli, err := azblob.NewClientWithNoCredential(sasURL, options)
+	if err != nil {
+		return nil, err
+	}
+	return &AzureClient{client: cli, retry: RetryOptions{}.withDefaults()}, nil
+}
+
+// NewAzureClientWithServicePrincipal constructs an AzureClient authenticated
+// as an Azure AD service principal identified by tenant, client ID and
+// client secret.
+func NewAzureClientWithServicePrincipal(accountURL, tenantID, clientID, clientSecret string, options *ClientOptions) (*AzureClient, error) {
+	cred, err := azidentity.NewClientSecretCredential(tenantID, clientID, clientSecret, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureClient(accountURL, cred, options)
+}
+
+// NewAzureClientWithManagedIdentity constructs an AzureClient authenticated
+// with the host's managed identity. clientID selects a specific
+// user-assigned identity; pass "" to use the system-assigned identity.
+func NewAzureClientWithManagedIdentity(accountURL, clientID string, options *ClientOptions) (*AzureClient, error) {
+	var idOpts *azidentity.ManagedIdentityCredentialOptions
+	if clientID != "" {
+		idOpts = &azidentity.ManagedIdentityCredentialOptions{ID: azidentity.ClientID(clientID)}
+	}
+	cred, err := azidentity.NewManagedIdentityCredential(idOpts)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureClient(accountURL, cred, options)
+}
+
+// NewAzureClientWithWorkloadIdentity constructs an AzureClient authenticated
+// via Azure AD Workload Identity, the federated-token flow used by AKS.
+func NewAzureClientWithWorkloadIdentity(accountURL string, options *ClientOptions) (*AzureClient, error) {
+	cred, err := azidentity.NewWorkloadIdentityCredential(nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureClient(accountURL, cred, options)
 }
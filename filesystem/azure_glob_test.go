@@ -0,0 +1,142 @@
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// fakeContainerPager and fakeBlobPager replay a fixed sequence of pages,
+// the same way a real Azure pager replays a continuation token (NextMarker)
+// across calls, so tests can exercise marker resumption without a live
+// account.
+type fakeContainerPager struct {
+	pages []containerPage
+	idx   int
+}
+
+func (p *fakeContainerPager) More() bool { return p.idx < len(p.pages) }
+
+func (p *fakeContainerPager) NextPage(context.Context) (containerPage, error) {
+	if !p.More() {
+		return containerPage{}, fmt.Errorf("fakeContainerPager: no more pages")
+	}
+	page := p.pages[p.idx]
+	p.idx++
+	return page, nil
+}
+
+type fakeBlobPager struct {
+	pages []blobPage
+	idx   int
+}
+
+func (p *fakeBlobPager) More() bool { return p.idx < len(p.pages) }
+
+func (p *fakeBlobPager) NextPage(context.Context) (blobPage, error) {
+	if !p.More() {
+		return blobPage{}, fmt.Errorf("fakeBlobPager: no more pages")
+	}
+	page := p.pages[p.idx]
+	p.idx++
+	return page, nil
+}
+
+// fakeGlobBackend is a globBackend over pre-built pages, keyed by container
+// name, and records the container and prefix of the most recent listBlobs
+// call so tests can assert server-side prefix filtering was actually
+// requested.
+type fakeGlobBackend struct {
+	containerPages    []containerPage
+	blobPagesByCnt    map[string][]blobPage
+	lastBlobPrefix    string
+	lastBlobContainer string
+}
+
+func (b *fakeGlobBackend) listContainers() containerPager {
+	return &fakeContainerPager{pages: b.containerPages}
+}
+
+func (b *fakeGlobBackend) listBlobs(containerName, prefix string) blobPager {
+	b.lastBlobContainer = containerName
+	b.lastBlobPrefix = prefix
+	return &fakeBlobPager{pages: b.blobPagesByCnt[containerName]}
+}
+
+func newTestAzureClient(backend *fakeGlobBackend) *AzureClient {
+	return &AzureClient{retry: RetryOptions{MaxAttempts: 1}.withDefaults(), glob: backend}
+}
+
+// TestGlobContextPaginatesThousandsOfBlobs checks that GlobContext follows a
+// blob listing across many pages (marker resumption) and matches a deep,
+// nested-directory blob pattern, well past the size of a single page.
+func TestGlobContextPaginatesThousandsOfBlobs(t *testing.T) {
+	const total = 5200
+	const pageSize = 1000
+	var pages []blobPage
+	for start := 0; start < total; start += pageSize {
+		end := start + pageSize
+		if end > total {
+			end = total
+		}
+		var page blobPage
+		for i := start; i < end; i++ {
+			page.names = append(page.names, fmt.Sprintf("a/b/part.%05d", i))
+		}
+		pages = append(pages, page)
+	}
+	// A blob in the same directory that the pattern does not match. The fake
+	// backend ignores the prefix, so this confirms the client-side
+	// path.Match filters it out.
+	pages[len(pages)-1].names = append(pages[len(pages)-1].names, "a/b/other")
+
+	backend := &fakeGlobBackend{
+		containerPages: []containerPage{{names: []string{"cnt"}}},
+		blobPagesByCnt: map[string][]blobPage{"cnt": pages},
+	}
+	c := newTestAzureClient(backend)
+
+	matches, err := c.GlobContext(context.Background(), "cnt/a/b/part.*")
+	if err != nil {
+		t.Fatalf("GlobContext: %v", err)
+	}
+	if len(matches) != total {
+		t.Fatalf("got %d matches, want %d", len(matches), total)
+	}
+	if backend.lastBlobContainer != "cnt" {
+		t.Fatalf("listBlobs called for container %q, want %q", backend.lastBlobContainer, "cnt")
+	}
+	if want := literalGlobPrefix("a/b/part.*"); backend.lastBlobPrefix != want {
+		t.Fatalf("listBlobs prefix = %q, want %q (server-side filtering)", backend.lastBlobPrefix, want)
+	}
+}
+
+// TestGlobContextPaginatesContainers checks that GlobContext follows a
+// container listing across multiple pages before it ever lists blobs.
+func TestGlobContextPaginatesContainers(t *testing.T) {
+	backend := &fakeGlobBackend{
+		containerPages: []containerPage{
+			{names: []string{"aaa", "bbb"}},
+			{names: []string{"abc", "xyz"}},
+		},
+		blobPagesByCnt: map[string][]blobPage{
+			"aaa": {{names: []string{"part.1"}}},
+			"abc": {{names: []string{"part.2"}}},
+		},
+	}
+	c := newTestAzureClient(backend)
+
+	matches, err := c.GlobContext(context.Background(), "a??/part.*")
+	if err != nil {
+		t.Fatalf("GlobContext: %v", err)
+	}
+	want := map[string]bool{"aaa/part.1": true, "abc/part.2": true}
+	if len(matches) != len(want) {
+		t.Fatalf("got %v, want matches for %v", matches, want)
+	}
+	for _, m := range matches {
+		if !want[m] {
+			t.Fatalf("unexpected match %q", m)
+		}
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"log"
 	"math"
 	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/coreos/go-etcd/etcd"
 	"github.com/taskgraph/taskgraph"
@@ -30,13 +34,28 @@ type framework struct {
 	etcdClient *etcd.Client
 	ln         net.Listener
 
+	// stateMu guards metaNotified, metaStops, and dataReqChan so AddTask and
+	// RemoveTask can reset a member's bookkeeping without racing each other.
+	stateMu sync.Mutex
+
+	// membersWatchOnce makes the members-directory watch idempotent to
+	// start: AddTask and RemoveTask both call ensureMembersWatch, but only
+	// the first call does anything. A NewFramework/Start bootstrap that
+	// every node runs once at launch would be the more natural place for
+	// this, but this package doesn't have one in this tree.
+	membersWatchOnce sync.Once
+
 	// A meta is a signal for specific epoch some task has some data.
 	// However, our fault tolerance mechanism will start another task if it failed
-	// and flag the same meta again. Therefore, we keep track of  notified meta.
-	metaNotified map[string]bool
+	// and flag the same meta again. Therefore, we keep track of notified meta,
+	// per taskID so admitting or evicting one member only resets that
+	// member's bucket instead of every task's fault-tolerance state.
+	metaNotified map[uint64]map[string]bool
 
-	// etcd stops
-	metaStops []chan bool
+	// etcd stops, one per currently-admitted task. AddTask creates taskID's
+	// entry (closing and replacing any stale one left by a prior occupant of
+	// that taskID) and RemoveTask closes and deletes it.
+	metaStops map[uint64]chan bool
 	epochStop chan bool
 
 	httpStop      chan struct{}
@@ -82,6 +101,231 @@ func (f *framework) incEpoch(epoch uint64) {
 	}
 }
 
+// membersPath returns the etcd directory that holds one key per admitted
+// task, keyed by taskID, mapping to that task's network address.
+func membersPath(name string) string {
+	return fmt.Sprintf("/taskgraph/%s/members", name)
+}
+
+// memberPath returns the etcd key that holds the network address of taskID
+// within job name. AddTask and RemoveTask write and delete this key;
+// ensureMembersWatch subscribes this node to the members directory so it
+// reloads its topology whenever any node's membership changes. Only
+// AddTask and RemoveTask start that watch.
+func memberPath(name string, taskID uint64) string {
+	return fmt.Sprintf("%s/%d", membersPath(name), taskID)
+}
+
+// parseMemberTaskID extracts the taskID from an etcd key below
+// membersPath(name), e.g. membersPath(name)+"/7" -> (7, true).
+func parseMemberTaskID(name, key string) (uint64, bool) {
+	prefix := membersPath(name) + "/"
+	if !strings.HasPrefix(key, prefix) {
+		return 0, false
+	}
+	taskID, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return taskID, true
+}
+
+// mutableTopology is the subset of topology implementations AddTask,
+// RemoveTask, and handleMemberChange need: taskgraph.Topology plus AddTask
+// and RemoveTask. Declaring the richer interface here, instead of widening
+// taskgraph.Topology itself, lets those methods compile without editing the
+// taskgraph package, which isn't part of this tree: any topology.Topology
+// implementation that also defines AddTask/RemoveTask (the "matching
+// Topology extensions" the request asked for) satisfies mutableTopology
+// automatically, with no change required on the taskgraph side.
+type mutableTopology interface {
+	taskgraph.Topology
+	AddTask(taskID uint64, addr string)
+	RemoveTask(taskID uint64)
+}
+
+// asMutableTopology asserts that f.topology supports dynamic membership,
+// returning an error the caller can surface instead of a panic if it
+// doesn't.
+func (f *framework) asMutableTopology() (mutableTopology, error) {
+	mt, ok := f.topology.(mutableTopology)
+	if !ok {
+		return nil, fmt.Errorf("framework: topology %T does not implement AddTask/RemoveTask", f.topology)
+	}
+	return mt, nil
+}
+
+// ensureMembersWatch starts watching the members directory exactly once per
+// framework instance. Each watch response is applied to the local topology
+// through handleMemberChange. It runs only on a node that has itself called
+// AddTask or RemoveTask; a node that has called neither does not apply
+// member changes to its topology.
+func (f *framework) ensureMembersWatch() {
+	f.membersWatchOnce.Do(func() {
+		receiver := make(chan *etcd.Response)
+		stop := make(chan bool)
+		go func() {
+			if _, err := f.etcdClient.Watch(membersPath(f.name), 0, true, receiver, stop); err != nil {
+				f.log.Printf("framework: members watch stopped: %v", err)
+			}
+		}()
+		go func() {
+			for resp := range receiver {
+				f.handleMemberChange(resp)
+			}
+		}()
+	})
+}
+
+// handleMemberChange applies a single etcd watch response for the members
+// directory to this node's topology.
+func (f *framework) handleMemberChange(resp *etcd.Response) {
+	taskID, ok := parseMemberTaskID(f.name, resp.Node.Key)
+	if !ok {
+		return
+	}
+	mt, err := f.asMutableTopology()
+	if err != nil {
+		f.log.Printf("framework: dropping member change for taskID %d: %v", taskID, err)
+		return
+	}
+	switch resp.Action {
+	case "delete", "expire":
+		mt.RemoveTask(taskID)
+		f.clearMemberState(taskID)
+	default:
+		mt.AddTask(taskID, resp.Node.Value)
+		f.resetMemberState(taskID)
+	}
+}
+
+// AddTask admits taskID at addr into the running job: it records the
+// member's address in etcd and bumps the epoch. The topology update and the
+// member's event-loop state reset are both driven by handleMemberChange via
+// the members watch started here; other nodes pick them up only if they
+// have started the same watch by calling AddTask or RemoveTask themselves.
+func (f *framework) AddTask(taskID uint64, addr string) error {
+	f.ensureMembersWatch()
+	if _, err := f.asMutableTopology(); err != nil {
+		return err
+	}
+	if _, err := f.etcdClient.Set(memberPath(f.name, taskID), addr, 0); err != nil {
+		return fmt.Errorf("framework: AddTask Set failed; taskID: %d, error: %v", taskID, err)
+	}
+	f.incEpoch(f.epoch)
+	return nil
+}
+
+// RemoveTask evicts taskID from the running job: it deletes the member's
+// etcd entry and bumps the epoch; handleMemberChange applies the topology
+// update and the per-member state teardown, the same way it does for
+// AddTask.
+func (f *framework) RemoveTask(taskID uint64) error {
+	f.ensureMembersWatch()
+	if _, err := f.asMutableTopology(); err != nil {
+		return err
+	}
+	if _, err := f.etcdClient.Delete(memberPath(f.name, taskID), false); err != nil {
+		return fmt.Errorf("framework: RemoveTask Delete failed; taskID: %d, error: %v", taskID, err)
+	}
+	f.incEpoch(f.epoch)
+	return nil
+}
+
+// resetMemberState atomically gives taskID a clean metaNotified bucket and a
+// fresh stop channel (closing any stale one a prior occupant of taskID left
+// behind), and hands out a new dataReqChan so requests addressed to
+// whatever task previously held taskID don't get delivered to the new one.
+// The event loop must read the current channel through DataReqChan on each
+// iteration rather than holding onto a value it read earlier, the same way
+// it already has to reread GetTopology after an epoch bump.
+func (f *framework) resetMemberState(taskID uint64) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	if f.metaNotified == nil {
+		f.metaNotified = make(map[uint64]map[string]bool)
+	}
+	f.metaNotified[taskID] = make(map[string]bool)
+	if f.metaStops == nil {
+		f.metaStops = make(map[uint64]chan bool)
+	}
+	if stop, ok := f.metaStops[taskID]; ok {
+		close(stop)
+	}
+	f.metaStops[taskID] = make(chan bool)
+	f.dataReqChan = make(chan *dataRequest)
+}
+
+// clearMemberState closes and forgets taskID's stop channel and discards its
+// metaNotified bucket, under the same lock resetMemberState uses.
+func (f *framework) clearMemberState(taskID uint64) {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	if stop, ok := f.metaStops[taskID]; ok {
+		close(stop)
+		delete(f.metaStops, taskID)
+	}
+	delete(f.metaNotified, taskID)
+}
+
+// DataReqChan returns the channel the event loop should currently select on
+// for incoming data requests. AddTask and RemoveTask, through
+// handleMemberChange, may swap this channel out from under a running loop,
+// so callers must call DataReqChan on every iteration instead of caching the
+// channel value.
+func (f *framework) DataReqChan() chan *dataRequest {
+	f.stateMu.Lock()
+	defer f.stateMu.Unlock()
+	return f.dataReqChan
+}
+
+// RegisterAdminRoutes adds framework's runtime membership endpoints to mux:
+//
+//	POST   /admin/tasks/<taskID>?addr=<addr>  AddTask(taskID, addr)
+//	DELETE /admin/tasks/<taskID>              RemoveTask(taskID)
+//
+// Call this when building the mux that frameworkhttp serves on f.ln, before
+// that single http.Serve call, so admin requests share frameworkhttp's
+// listener and Accept loop instead of a second one racing it for the same
+// connections.
+func (f *framework) RegisterAdminRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/tasks/", func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/admin/tasks/"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			addr := r.URL.Query().Get("addr")
+			if addr == "" {
+				http.Error(w, "missing addr query parameter", http.StatusBadRequest)
+				return
+			}
+			err = f.AddTask(taskID, addr)
+		case http.MethodDelete:
+			err = f.RemoveTask(taskID)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// AdminHandler returns a standalone http.Handler serving just the admin
+// routes from RegisterAdminRoutes, for callers that genuinely want framework
+// admin on its own mux rather than composed into frameworkhttp's.
+func (f *framework) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	f.RegisterAdminRoutes(mux)
+	return mux
+}
+
 func (f *framework) dataRequest(toID uint64, req string, epoch uint64) {
 	// assumption here:
 	// Event driven task will call this in a synchronous way so that
@@ -120,4 +364,4 @@ func (f *framework) GetLogger() *log.Logger { return f.log }
 
 func (f *framework) GetTaskID() uint64 { return f.taskID }
 
-func (f *framework) GetEpoch() uint64 { return f.epoch }
\ No newline at end of file
+func (f *framework) GetEpoch() uint64 { return f.epoch }